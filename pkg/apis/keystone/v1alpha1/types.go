@@ -0,0 +1,106 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package v1alpha1 contains the User and Group custom resources that
+// mirror Keystone principals into the Kubernetes API, so cluster admins
+// can see and RBAC against them as first-class objects instead of only
+// at webhook time.
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// User mirrors a single Keystone user.
+type User struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   UserSpec   `json:"spec"`
+	Status SyncStatus `json:"status,omitempty"`
+}
+
+// UserSpec is the desired state synced from Keystone.
+type UserSpec struct {
+	// KeystoneID is the Keystone user id this resource mirrors.
+	KeystoneID string `json:"keystoneID"`
+	// Domain is the Keystone domain the user belongs to.
+	Domain string `json:"domain"`
+	// ProjectMemberships lists the Keystone projects this user has a
+	// role assignment in.
+	ProjectMemberships []ProjectMembership `json:"projectMemberships,omitempty"`
+}
+
+// ProjectMembership is a single Keystone project/role assignment.
+type ProjectMembership struct {
+	ProjectID   string   `json:"projectID"`
+	ProjectName string   `json:"projectName"`
+	Roles       []string `json:"roles"`
+}
+
+// SyncStatus records the outcome of the last reconciliation from Keystone.
+type SyncStatus struct {
+	// LastSyncTime is when this resource was last reconciled against
+	// Keystone.
+	LastSyncTime metav1.Time `json:"lastSyncTime,omitempty"`
+	// Conditions holds the latest observations, e.g. "Synced".
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// UserList is a list of Users.
+type UserList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []User `json:"items"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// Group mirrors a single Keystone project, with the users that hold a
+// role assignment in it.
+type Group struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   GroupSpec  `json:"spec"`
+	Status SyncStatus `json:"status,omitempty"`
+}
+
+// GroupSpec is the desired state synced from Keystone.
+type GroupSpec struct {
+	// KeystoneID is the Keystone project id this resource mirrors.
+	KeystoneID string `json:"keystoneID"`
+	// Domain is the Keystone domain the project belongs to.
+	Domain string `json:"domain"`
+	// Members lists the Keystone user ids with a role assignment in
+	// this project.
+	Members []string `json:"members,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// GroupList is a list of Groups.
+type GroupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []Group `json:"items"`
+}
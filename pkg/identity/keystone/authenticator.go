@@ -0,0 +1,194 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+
+	k8suser "k8s.io/apiserver/pkg/authentication/user"
+)
+
+// Authenticator implements the TokenReview side of the webhook. It tries a
+// chain of IdentityProviders in order, tagging the winning user's Extra
+// with which provider vouched for them.
+type Authenticator struct {
+	authURL string
+	client  *gophercloud.ServiceClient
+
+	providers []IdentityProvider
+
+	// cache, when set, fronts the provider chain with an LRU result
+	// cache, rate limiter and circuit breaker so repeated or
+	// pathological TokenReview traffic doesn't turn into one Keystone
+	// round-trip per request.
+	cache *cachedKeystoneClient
+
+	mu         sync.Mutex
+	syncConfig *syncConfig
+}
+
+// AuthenticateToken tries each provider in the chain in order, returning
+// the identity of the first one to accept the token.
+func (a *Authenticator) AuthenticateToken(token string) (k8suser.Info, bool, error) {
+	if a.cache != nil {
+		info, err := a.cache.Validate(token)
+		return info, err == nil, nil
+	}
+	return a.authenticateTokenUncached(token)
+}
+
+// errTokenRejected is returned by authenticateTokenUncached when every
+// configured IdentityProvider turned the token down outright (as opposed
+// to failing to reach its backend). A backend failure is returned as-is
+// instead, also alongside ok == false; errTokenRejected is what
+// cachedKeystoneClient treats as safe to remember in its negative cache.
+type errTokenRejected struct{}
+
+func (errTokenRejected) Error() string { return "token rejected by all identity providers" }
+
+// authenticateTokenUncached is the validateFunc the cache wraps: it
+// walks the provider chain against Keystone (and any other configured
+// identity providers) with no cache in front, stopping at the first
+// provider that accepts the token.
+func (a *Authenticator) authenticateTokenUncached(token string) (k8suser.Info, bool, error) {
+	var lastErr error
+	for _, provider := range a.providers {
+		info, err := provider.Authenticate(token)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		extra := info.GetExtra()
+		if extra == nil {
+			extra = map[string][]string{}
+		}
+		extra["identity_provider"] = []string{provider.Type()}
+
+		return &k8suser.DefaultInfo{
+			Name:   info.GetName(),
+			UID:    info.GetUID(),
+			Groups: info.GetGroups(),
+			Extra:  extra,
+		}, true, nil
+	}
+
+	glog.V(4).Infof("no identity provider accepted the token: %v", lastErr)
+	if isAuthRejectionError(lastErr) {
+		return nil, false, errTokenRejected{}
+	}
+	return nil, false, lastErr
+}
+
+// keystoneProvider is the built-in IdentityProvider backed by Keystone; it
+// is always the first link in the authentication chain.
+type keystoneProvider struct {
+	authURL string
+	client  *gophercloud.ServiceClient
+}
+
+func (p *keystoneProvider) Type() string {
+	return "keystone"
+}
+
+func (p *keystoneProvider) Authenticate(token string) (k8suser.Info, error) {
+	result := tokens.Get(p.client, token)
+	tokenInfo, err := result.Extract()
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := result.ExtractUser()
+	if err != nil {
+		return nil, err
+	}
+
+	project, err := result.ExtractProject()
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := result.ExtractRoles()
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]string, 0, len(roles))
+	for _, role := range roles {
+		groups = append(groups, role.Name)
+	}
+
+	extra := map[string][]string{}
+	if project != nil {
+		extra["project_id"] = []string{project.ID}
+		extra["project_name"] = []string{project.Name}
+	}
+	_ = tokenInfo
+
+	return &k8suser.DefaultInfo{
+		Name:   user.Name,
+		UID:    user.ID,
+		Groups: groups,
+		Extra:  extra,
+	}, nil
+}
+
+// authenticateKeystonePassword exchanges a username/password pair for a
+// Keystone-backed identity, used by the password grant of /oauth/token
+// and /oauth/authorize. Unlike createKeystoneClient, it authenticates a
+// specific user rather than obtaining an unscoped service token.
+func authenticateKeystonePassword(authURL, username, password string) (k8suser.Info, error) {
+	opts := gophercloud.AuthOptions{
+		IdentityEndpoint: authURL,
+		Username:         username,
+		Password:         password,
+	}
+
+	provider, err := openstack.AuthenticatedClient(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate against keystone: %v", err)
+	}
+
+	client, err := openstack.NewIdentityV3(provider, gophercloud.EndpointOpts{})
+	if err != nil {
+		return nil, err
+	}
+
+	result := tokens.Get(client, provider.Token())
+	user, err := result.ExtractUser()
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := result.ExtractRoles()
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]string, 0, len(roles))
+	for _, role := range roles {
+		groups = append(groups, role.Name)
+	}
+
+	return &k8suser.DefaultInfo{Name: user.Name, UID: user.ID, Groups: groups}, nil
+}
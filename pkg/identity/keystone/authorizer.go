@@ -0,0 +1,71 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"sync"
+
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+// Authorizer implements the SubjectAccessReview side of the webhook,
+// deciding whether a request is allowed based on the policies synced from
+// the policy file/configmap. Unlike Authenticator, it never calls out to
+// Keystone itself, so it has no need for the result cache/rate
+// limiter/circuit breaker that fronts Keystone token validation.
+type Authorizer struct {
+	mu sync.Mutex
+	pl policyList
+}
+
+// Authorize evaluates attrs against the current policy list and returns an
+// allow/deny decision.
+func (a *Authorizer) Authorize(attrs authorizer.Attributes) (authorizer.Decision, string, error) {
+	a.mu.Lock()
+	pl := a.pl
+	a.mu.Unlock()
+
+	for _, p := range pl {
+		if policyMatches(p, attrs) {
+			return authorizer.DecisionAllow, "", nil
+		}
+	}
+
+	return authorizer.DecisionDeny, "no matching policy found", nil
+}
+
+func policyMatches(p *policy, attrs authorizer.Attributes) bool {
+	if attrs.IsResourceRequest() {
+		return matchesVerb(p.Resource.Verbs, attrs.GetVerb()) &&
+			matchesStringList(p.Resource.Resources, attrs.GetResource())
+	}
+	return matchesVerb(p.NonResource.Verbs, attrs.GetVerb()) &&
+		(p.NonResource.Path == "" || p.NonResource.Path == attrs.GetPath())
+}
+
+func matchesVerb(verbs []string, verb string) bool {
+	return matchesStringList(verbs, verb)
+}
+
+func matchesStringList(list []string, value string) bool {
+	for _, v := range list {
+		if v == "*" || v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,338 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"golang.org/x/time/rate"
+
+	k8suser "k8s.io/apiserver/pkg/authentication/user"
+)
+
+const (
+	defaultCachePositiveTTL               = 5 * time.Minute
+	defaultCacheNegativeTTL               = 30 * time.Second
+	defaultCacheMaxEntries                = 10000
+	defaultRateLimitQPS                   = 50
+	defaultRateLimitBurst                 = 100
+	defaultCircuitBreakerFailureThreshold = 5
+	defaultCircuitBreakerResetTimeout     = 30 * time.Second
+)
+
+// validateFunc performs the real, uncached Keystone token validation.
+type validateFunc func(token string) (k8suser.Info, error)
+
+// cacheEntry is what each list.Element.Value in cachedKeystoneClient.order
+// holds.
+type cacheEntry struct {
+	key    string
+	info   k8suser.Info
+	err    error
+	expiry time.Time
+}
+
+// cachedKeystoneClient sits in front of a validateFunc, giving it an LRU
+// result cache, a token-bucket rate limiter and a circuit breaker, so a
+// Keystone outage or a hot path of repeated TokenReviews doesn't turn
+// into a synchronous Keystone round-trip per kube-apiserver request.
+type cachedKeystoneClient struct {
+	validate validateFunc
+
+	positiveTTL time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+
+	limiter *rate.Limiter
+	breaker *circuitBreaker
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// newCachedKeystoneClient builds a cachedKeystoneClient, filling in
+// defaults for any zero-valued tuning knob in c.
+func newCachedKeystoneClient(validate validateFunc, c *Config) *cachedKeystoneClient {
+	positiveTTL := c.CachePositiveTTL
+	if positiveTTL <= 0 {
+		positiveTTL = defaultCachePositiveTTL
+	}
+	negativeTTL := c.CacheNegativeTTL
+	if negativeTTL <= 0 {
+		negativeTTL = defaultCacheNegativeTTL
+	}
+	maxEntries := c.CacheMaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheMaxEntries
+	}
+	qps := c.RateLimitQPS
+	if qps <= 0 {
+		qps = defaultRateLimitQPS
+	}
+	burst := c.RateLimitBurst
+	if burst <= 0 {
+		burst = defaultRateLimitBurst
+	}
+	failureThreshold := c.CircuitBreakerFailureThreshold
+	if failureThreshold <= 0 {
+		failureThreshold = defaultCircuitBreakerFailureThreshold
+	}
+	resetTimeout := c.CircuitBreakerResetTimeout
+	if resetTimeout <= 0 {
+		resetTimeout = defaultCircuitBreakerResetTimeout
+	}
+
+	return &cachedKeystoneClient{
+		validate:    validate,
+		positiveTTL: positiveTTL,
+		negativeTTL: negativeTTL,
+		maxEntries:  maxEntries,
+		limiter:     rate.NewLimiter(rate.Limit(qps), burst),
+		breaker:     newCircuitBreaker(failureThreshold, resetTimeout),
+		entries:     make(map[string]*list.Element),
+		order:       list.New(),
+	}
+}
+
+// Validate returns the cached result for token if one is still fresh,
+// otherwise validates it for real (subject to the rate limiter and
+// circuit breaker) and caches the outcome.
+func (c *cachedKeystoneClient) Validate(token string) (k8suser.Info, error) {
+	key := hashToken(token)
+
+	if entry, ok := c.get(key); ok {
+		cacheHitsTotal.WithLabelValues(hitResult(entry.err)).Inc()
+		return entry.info, entry.err
+	}
+	cacheHitsTotal.WithLabelValues("miss").Inc()
+
+	if !c.breaker.Allow() {
+		authErrorsTotal.WithLabelValues("circuit_open").Inc()
+		return nil, fmt.Errorf("keystone circuit breaker open, failing fast")
+	}
+
+	if !c.limiter.Allow() {
+		authErrorsTotal.WithLabelValues("rate_limited").Inc()
+		return nil, fmt.Errorf("rate limited waiting for keystone")
+	}
+
+	start := time.Now()
+	info, err := c.validate(token)
+	authLatencySeconds.WithLabelValues(hitResult(err)).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		if isAuthRejectionError(err) {
+			// Keystone (or another identity provider) answered and said
+			// no: that's a real result, safe to remember as such and not
+			// a sign that Keystone itself is unhealthy.
+			authErrorsTotal.WithLabelValues("rejected").Inc()
+			c.breaker.RecordSuccess()
+			c.put(key, info, err, c.negativeTTL)
+			return nil, err
+		}
+
+		// A transport/availability failure (timeout, 5xx, network error)
+		// says nothing about whether the token is valid, so it must not
+		// be cached as a negative result -- only that Keystone itself
+		// looks unhealthy right now.
+		c.breaker.RecordFailure()
+		authErrorsTotal.WithLabelValues("keystone_error").Inc()
+		return nil, err
+	}
+
+	c.breaker.RecordSuccess()
+	c.put(key, info, nil, c.positiveTTL)
+	return info, nil
+}
+
+// isAuthRejectionError reports whether err represents Keystone (or
+// another identity provider) genuinely rejecting a token -- as opposed to
+// a transport/availability failure such as a timeout, a 5xx or a network
+// error, which says nothing about the token itself.
+func isAuthRejectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := err.(errTokenRejected); ok {
+		return true
+	}
+	switch err.(type) {
+	case gophercloud.ErrDefault400, gophercloud.ErrDefault401, gophercloud.ErrDefault403, gophercloud.ErrDefault404:
+		return true
+	}
+	return false
+}
+
+// Invalidate evicts a single token's cached result, e.g. after a
+// password change or explicit logout.
+func (c *cachedKeystoneClient) Invalidate(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := hashToken(token)
+	if el, ok := c.entries[key]; ok {
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+// InvalidateAll clears the entire cache, used by the admin invalidation
+// endpoint.
+func (c *cachedKeystoneClient) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.order = list.New()
+}
+
+func (c *cachedKeystoneClient) get(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expiry) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	return entry, true
+}
+
+func (c *cachedKeystoneClient) put(key string, info k8suser.Info, err error, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+
+	entry := &cacheEntry{key: key, info: info, err: err, expiry: time.Now().Add(ttl)}
+	el := c.order.PushFront(entry)
+	c.entries[key] = el
+
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		if oldest == nil {
+			break
+		}
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// invalidateCacheHandler lets an admin evict a single token (?token=...)
+// or drop the entire token cache, e.g. after rotating Keystone
+// credentials or suspecting a compromised token.
+func (k *KeystoneAuth) invalidateCacheHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if token := r.FormValue("token"); token != "" {
+		k.authn.cache.Invalidate(token)
+	} else {
+		k.authn.cache.InvalidateAll()
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func hitResult(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// circuitBreaker is a minimal closed/open/half-open breaker: it opens
+// after failureThreshold consecutive failures, fails fast for
+// resetTimeout, then lets a single trial request through before either
+// closing again (on success) or re-opening (on failure).
+type circuitBreaker struct {
+	failureThreshold int
+	resetTimeout     time.Duration
+
+	mu          sync.Mutex
+	failures    int
+	openedAt    time.Time
+	halfOpenTry bool
+}
+
+func newCircuitBreaker(failureThreshold int, resetTimeout time.Duration) *circuitBreaker {
+	return &circuitBreaker{failureThreshold: failureThreshold, resetTimeout: resetTimeout}
+}
+
+// Allow reports whether a request should be let through.
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.failureThreshold {
+		return true
+	}
+
+	if time.Since(b.openedAt) < b.resetTimeout {
+		return false
+	}
+
+	// Past the reset timeout: let exactly one trial request through.
+	if b.halfOpenTry {
+		return false
+	}
+	b.halfOpenTry = true
+	return true
+}
+
+// RecordSuccess closes the breaker.
+func (b *circuitBreaker) RecordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.halfOpenTry = false
+}
+
+// RecordFailure counts a failure, opening the breaker once the threshold
+// is reached.
+func (b *circuitBreaker) RecordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.halfOpenTry = false
+	if b.failures >= b.failureThreshold {
+		b.openedAt = time.Now()
+	}
+}
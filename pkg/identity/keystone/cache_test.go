@@ -0,0 +1,182 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+
+	k8suser "k8s.io/apiserver/pkg/authentication/user"
+)
+
+func newTestCache(validate validateFunc) *cachedKeystoneClient {
+	return newCachedKeystoneClient(validate, &Config{
+		CachePositiveTTL:               time.Minute,
+		CacheNegativeTTL:               time.Minute,
+		CacheMaxEntries:                2,
+		RateLimitQPS:                   1000,
+		RateLimitBurst:                 1000,
+		CircuitBreakerFailureThreshold: 2,
+		CircuitBreakerResetTimeout:     time.Minute,
+	})
+}
+
+func TestCacheValidatePositiveHit(t *testing.T) {
+	calls := 0
+	c := newTestCache(func(token string) (k8suser.Info, error) {
+		calls++
+		return &k8suser.DefaultInfo{Name: "alice"}, nil
+	})
+
+	for i := 0; i < 3; i++ {
+		info, err := c.Validate("good-token")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if info.GetName() != "alice" {
+			t.Fatalf("got name %q, want alice", info.GetName())
+		}
+	}
+
+	if calls != 1 {
+		t.Fatalf("validate called %d times, want 1 (later calls should hit the cache)", calls)
+	}
+}
+
+func TestCacheValidateCachesGenuineRejection(t *testing.T) {
+	calls := 0
+	c := newTestCache(func(token string) (k8suser.Info, error) {
+		calls++
+		return nil, gophercloud.ErrDefault401{}
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Validate("bad-token"); err == nil {
+			t.Fatal("expected error for rejected token")
+		}
+	}
+
+	if calls != 1 {
+		t.Fatalf("validate called %d times, want 1 (rejection should be cached)", calls)
+	}
+}
+
+func TestCacheValidateDoesNotCacheTransportError(t *testing.T) {
+	calls := 0
+	c := newTestCache(func(token string) (k8suser.Info, error) {
+		calls++
+		return nil, fmt.Errorf("connection timed out")
+	})
+	// newTestCache's breaker opens after 2 failures, which would fail the
+	// third call fast without reaching validate; raise the threshold so
+	// this test only exercises the (non-)caching of transport errors.
+	c.breaker = newCircuitBreaker(10, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Validate("some-token"); err == nil {
+			t.Fatal("expected error from validate")
+		}
+	}
+
+	if calls != 3 {
+		t.Fatalf("validate called %d times, want 3 (transport errors must not be cached)", calls)
+	}
+}
+
+func TestCacheValidateEvictsLRU(t *testing.T) {
+	calls := map[string]int{}
+	c := newTestCache(func(token string) (k8suser.Info, error) {
+		calls[token]++
+		return &k8suser.DefaultInfo{Name: token}, nil
+	})
+
+	// CacheMaxEntries is 2, so adding a third distinct token must evict
+	// the least recently used entry ("a").
+	if _, err := c.Validate("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Validate("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Validate("c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Validate("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls["a"] != 2 {
+		t.Fatalf("token \"a\" validated %d times, want 2 (should have been evicted)", calls["a"])
+	}
+}
+
+func TestCacheValidateExpiresEntries(t *testing.T) {
+	calls := 0
+	c := newCachedKeystoneClient(func(token string) (k8suser.Info, error) {
+		calls++
+		return &k8suser.DefaultInfo{Name: "alice"}, nil
+	}, &Config{
+		CachePositiveTTL: time.Millisecond,
+		CacheMaxEntries:  10,
+		RateLimitQPS:     1000,
+		RateLimitBurst:   1000,
+	})
+
+	if _, err := c.Validate("token"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := c.Validate("token"); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 2 {
+		t.Fatalf("validate called %d times, want 2 (expired entry should miss)", calls)
+	}
+}
+
+func TestCircuitBreakerOpensAndResets(t *testing.T) {
+	b := newCircuitBreaker(2, 10*time.Millisecond)
+
+	if !b.Allow() {
+		t.Fatal("breaker should start closed")
+	}
+	b.RecordFailure()
+	if !b.Allow() {
+		t.Fatal("breaker should stay closed below the failure threshold")
+	}
+	b.RecordFailure()
+	if b.Allow() {
+		t.Fatal("breaker should open once the failure threshold is reached")
+	}
+
+	time.Sleep(15 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("breaker should allow a single trial request after resetTimeout")
+	}
+	if b.Allow() {
+		t.Fatal("breaker should not allow a second concurrent trial request")
+	}
+
+	b.RecordSuccess()
+	if !b.Allow() {
+		t.Fatal("breaker should close again after a successful trial request")
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import "time"
+
+// Config is the configuration format for the keystone webhook server.
+type Config struct {
+	// KeystoneURL is the Keystone identity v3 endpoint used for
+	// authentication and authorization.
+	KeystoneURL string
+	// KeystoneCA is the path to a CA bundle used to validate the Keystone
+	// endpoint's TLS certificate.
+	KeystoneCA string
+
+	// Address is the address the webhook server listens on.
+	Address string
+	// CertFile and KeyFile are the TLS certificate/key pair used by the
+	// webhook server.
+	CertFile string
+	KeyFile  string
+
+	// Kubeconfig is used to build a client to the Kubernetes API server,
+	// required whenever either ConfigMap name below is set.
+	Kubeconfig string
+
+	// PolicyFile and PolicyConfigMapName configure the authorization
+	// policy. PolicyFile takes precedence at startup, but the policy is
+	// refreshed from the configmap whenever the configmap changes. With
+	// neither set, every SubjectAccessReview is denied.
+	PolicyFile          string
+	PolicyConfigMapName string
+
+	// SyncConfigFile and SyncConfigMapName configure how Keystone users,
+	// projects and roles get synchronized into Kubernetes RBAC.
+	SyncConfigFile    string
+	SyncConfigMapName string
+
+	// IdentityProviders lists additional identity providers, by the name
+	// they registered with RegisterIdentityProvider, to try after
+	// Keystone when authenticating a token. This lets operators front
+	// hybrid clusters where not every human is in Keystone, e.g.
+	// ["github", "oidc"].
+	IdentityProviders []string
+
+	// SigningAlgorithm selects the JWT signing method used by the
+	// built-in /oauth/token endpoint, "HS256" or "RS256".
+	SigningAlgorithm string
+	// SigningKeyFile points at the HMAC secret (HS256) or PEM-encoded
+	// private key (RS256) used to sign issued tokens.
+	SigningKeyFile string
+	// SigningKeySecretName, when set, is the name of a Secret in
+	// cmNamespace that the webhook watches and reloads signing keys from,
+	// taking precedence over SigningKeyFile once the informer syncs.
+	SigningKeySecretName string
+	// AccessTokenTTL and RefreshTokenTTL bound the lifetime of tokens
+	// minted by the password grant. They default to 1h and 720h.
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// OAuthRedirectURIs allow-lists the redirect_uri values /oauth/authorize
+	// will redirect to. A request naming any other redirect_uri is
+	// rejected, since redirecting to a caller-supplied URI with a bearer
+	// token in the fragment would otherwise be an open redirect handing
+	// out access tokens to any caller.
+	OAuthRedirectURIs []string
+
+	// EnableUserSync turns on the usercontroller, which mirrors Keystone
+	// users/projects/role-assignments into User/Group custom resources
+	// and the ClusterRoleBindings the sync config's role mappings call
+	// for. It requires Kubeconfig (or in-cluster config) to be usable,
+	// same as PolicyConfigMapName/SyncConfigMapName.
+	EnableUserSync bool
+	// UserSyncPeriod is how often the usercontroller re-enumerates
+	// Keystone. Defaults to 5m.
+	UserSyncPeriod time.Duration
+
+	// CacheEnabled turns on the token cache, rate limiter and circuit
+	// breaker in front of Keystone. Every kube-apiserver TokenReview
+	// otherwise causes a synchronous Keystone round-trip, which is a
+	// well-known scaling bottleneck.
+	CacheEnabled bool
+	// CachePositiveTTL/CacheNegativeTTL bound how long a successful or
+	// rejected token validation is cached for. Default to 5m and 30s.
+	// Transport/availability failures talking to Keystone are not cached.
+	CachePositiveTTL time.Duration
+	CacheNegativeTTL time.Duration
+	// CacheMaxEntries bounds the token cache's size; the least recently
+	// used entry is evicted once it's full. Defaults to 10000.
+	CacheMaxEntries int
+	// RateLimitQPS/RateLimitBurst bound the rate of requests allowed
+	// through to Keystone once the cache misses. Defaults to 50/100.
+	RateLimitQPS   float64
+	RateLimitBurst int
+	// CircuitBreakerFailureThreshold consecutive Keystone failures trip
+	// the breaker, which then fails fast for CircuitBreakerResetTimeout
+	// before allowing a trial request through again. Default to 5 and
+	// 30s.
+	CircuitBreakerFailureThreshold int
+	CircuitBreakerResetTimeout     time.Duration
+}
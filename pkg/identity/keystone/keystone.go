@@ -28,15 +28,17 @@ import (
 	"github.com/gophercloud/gophercloud/openstack"
 	"github.com/gophercloud/gophercloud/openstack/utils"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"gopkg.in/yaml.v2"
 	apiv1 "k8s.io/api/core/v1"
+	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	netutil "k8s.io/apimachinery/pkg/util/net"
 	runtimeutil "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	k8suser "k8s.io/apiserver/pkg/authentication/user"
-	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	corelisters "k8s.io/client-go/listers/core/v1"
@@ -44,6 +46,8 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 	certutil "k8s.io/client-go/util/cert"
 	"k8s.io/client-go/util/workqueue"
+
+	"k8s.io/cloud-provider-openstack/pkg/identity/keystone/usercontroller"
 )
 
 const (
@@ -51,18 +55,6 @@ const (
 	cmNamespace = "kube-system"
 )
 
-type userInfo struct {
-	Username string              `json:"username"`
-	UID      string              `json:"uid"`
-	Groups   []string            `json:"groups"`
-	Extra    map[string][]string `json:"extra"`
-}
-
-type status struct {
-	Authenticated bool     `json:"authenticated"`
-	User          userInfo `json:"user"`
-}
-
 // KeystoneAuth manages authentication and authorization
 type KeystoneAuth struct {
 	authn          *Authenticator
@@ -74,6 +66,19 @@ type KeystoneAuth struct {
 	informer       informers.SharedInformerFactory
 	cmLister       corelisters.ConfigMapLister
 	cmListerSynced cache.InformerSynced
+
+	// issuer and refreshTokens back the built-in /oauth/token endpoint.
+	issuer        TokenIssuer
+	refreshTokens *refreshTokenStore
+
+	// secretLister/secretListerSynced back the signing key rotator, only
+	// populated when Config.SigningKeySecretName is set.
+	secretLister       corelisters.SecretLister
+	secretListerSynced cache.InformerSynced
+
+	// userController, when Config.EnableUserSync is set, mirrors
+	// Keystone principals into User/Group custom resources.
+	userController *usercontroller.Controller
 }
 
 // Run starts the keystone webhook server.
@@ -85,7 +90,11 @@ func (k *KeystoneAuth) Run() {
 		go k.informer.Start(k.stopCh)
 
 		// wait for the caches to synchronize before starting the worker
-		if !cache.WaitForCacheSync(k.stopCh, k.cmListerSynced) {
+		syncedFuncs := []cache.InformerSynced{k.cmListerSynced}
+		if k.secretListerSynced != nil {
+			syncedFuncs = append(syncedFuncs, k.secretListerSynced)
+		}
+		if !cache.WaitForCacheSync(k.stopCh, syncedFuncs...) {
 			runtimeutil.HandleError(fmt.Errorf("timed out waiting for caches to sync"))
 			return
 		}
@@ -94,8 +103,20 @@ func (k *KeystoneAuth) Run() {
 		go wait.Until(k.runWorker, time.Second, k.stopCh)
 	}
 
+	if k.userController != nil {
+		go k.userController.Run(k.stopCh)
+	}
+
 	r := mux.NewRouter()
 	r.HandleFunc("/webhook", k.Handler)
+	if k.issuer != nil {
+		r.HandleFunc("/oauth/token", k.oauthTokenHandler).Methods(http.MethodPost)
+		r.HandleFunc("/oauth/authorize", k.oauthAuthorizeHandler).Methods(http.MethodPost)
+	}
+	if k.authn.cache != nil {
+		r.HandleFunc("/admin/cache/invalidate", k.invalidateCacheHandler).Methods(http.MethodPost)
+	}
+	r.Handle("/metrics", promhttp.Handler())
 
 	glog.Infof("Starting webhook server...")
 	glog.Fatal(http.ListenAndServeTLS(k.config.Address, k.config.CertFile, k.config.KeyFile, r))
@@ -221,152 +242,6 @@ func (k *KeystoneAuth) processItem(key string) error {
 	return nil
 }
 
-// Handler serves the http requests
-func (k *KeystoneAuth) Handler(w http.ResponseWriter, r *http.Request) {
-	var data map[string]interface{}
-	decoder := json.NewDecoder(r.Body)
-	defer r.Body.Close()
-	err := decoder.Decode(&data)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	var apiVersion = data["apiVersion"].(string)
-	var kind = data["kind"].(string)
-
-	if apiVersion != "authentication.k8s.io/v1beta1" && apiVersion != "authorization.k8s.io/v1beta1" {
-		http.Error(w, fmt.Sprintf("unknown apiVersion %q", apiVersion), http.StatusBadRequest)
-		return
-	}
-
-	if kind == "TokenReview" {
-		var token = data["spec"].(map[string]interface{})["token"].(string)
-		k.authenticateToken(w, r, token, data)
-	} else if kind == "SubjectAccessReview" {
-		k.authorizeToken(w, r, data)
-	} else {
-		http.Error(w, fmt.Sprintf("unknown kind/apiVersion %q %q", kind, apiVersion), http.StatusBadRequest)
-	}
-}
-
-func (k *KeystoneAuth) authenticateToken(w http.ResponseWriter, r *http.Request, token string, data map[string]interface{}) {
-	user, authenticated, err := k.authn.AuthenticateToken(token)
-	glog.V(4).Infof("authenticateToken : %v, %v, %v\n", token, user, err)
-
-	if !authenticated {
-		var response status
-		response.Authenticated = false
-		data["status"] = response
-
-		output, err := json.MarshalIndent(data, "", "  ")
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write(output)
-		return
-	}
-
-	var info userInfo
-	info.Username = user.GetName()
-	info.UID = user.GetUID()
-	info.Groups = user.GetGroups()
-	info.Extra = user.GetExtra()
-
-	var response status
-	response.Authenticated = true
-	response.User = info
-
-	data["status"] = response
-
-	output, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(output)
-}
-
-func (k *KeystoneAuth) authorizeToken(w http.ResponseWriter, r *http.Request, data map[string]interface{}) {
-	output, err := json.MarshalIndent(data, "", "  ")
-	glog.V(4).Infof("authorizeToken data : %s\n", string(output))
-
-	spec := data["spec"].(map[string]interface{})
-
-	username := spec["user"]
-	usr := &k8suser.DefaultInfo{Name: username.(string)}
-	attrs := authorizer.AttributesRecord{User: usr}
-
-	groups := spec["group"].([]interface{})
-	for _, v := range groups {
-		usr.Groups = append(usr.Groups, v.(string))
-	}
-	if extras, ok := spec["extra"].(map[string]interface{}); ok {
-		usr.Extra = make(map[string][]string, len(extras))
-		for key, value := range extras {
-			for _, v := range value.([]interface{}) {
-				if data, ok := usr.Extra[key]; ok {
-					usr.Extra[key] = append(data, v.(string))
-				} else {
-					usr.Extra[key] = []string{v.(string)}
-				}
-			}
-		}
-	}
-
-	if resourceAttributes, ok := spec["resourceAttributes"]; ok {
-		v := resourceAttributes.(map[string]interface{})
-		attrs.ResourceRequest = true
-		attrs.Verb = getField(v, "verb")
-		attrs.Namespace = getField(v, "namespace")
-		attrs.APIGroup = getField(v, "group")
-		attrs.APIVersion = getField(v, "version")
-		attrs.Resource = getField(v, "resource")
-		attrs.Name = getField(v, "name")
-	} else if nonResourceAttributes, ok := spec["nonResourceAttributes"]; ok {
-		v := nonResourceAttributes.(map[string]interface{})
-		attrs.ResourceRequest = false
-		attrs.Verb = getField(v, "verb")
-		attrs.Path = getField(v, "path")
-	} else {
-		err := fmt.Errorf("unable to find attributes")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	var allowed authorizer.Decision
-	if len(k.authz.pl) > 0 {
-		var reason string
-		allowed, reason, err = k.authz.Authorize(attrs)
-		glog.V(4).Infof("<<<< authorizeToken: %v, %v, %v\n", allowed, reason, err)
-		if err != nil {
-			http.Error(w, reason, http.StatusInternalServerError)
-			return
-		}
-	} else {
-		// The operator didn't set authorization policy, deny by default.
-		allowed = authorizer.DecisionDeny
-	}
-
-	delete(data, "spec")
-	data["status"] = map[string]interface{}{
-		"allowed": allowed == authorizer.DecisionAllow,
-	}
-	output, err = json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(output)
-}
-
 // NewKeystoneAuth returns a new KeystoneAuth controller
 func NewKeystoneAuth(c *Config) (*KeystoneAuth, error) {
 	keystoneClient, err := createKeystoneClient(c.KeystoneURL, c.KeystoneCA)
@@ -452,12 +327,50 @@ func NewKeystoneAuth(c *Config) (*KeystoneAuth, error) {
 		sc.validate()
 	}
 
+	providers, err := buildIdentityProviderChain(c, &keystoneProvider{authURL: c.KeystoneURL, client: keystoneClient})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build identity provider chain: %v", err)
+	}
+
 	keystoneAuth := &KeystoneAuth{
-		authn:     &Authenticator{authURL: c.KeystoneURL, client: keystoneClient, k8sClient: k8sClient, syncConfig: sc},
-		authz:     &Authorizer{authURL: c.KeystoneURL, client: keystoneClient, pl: policy},
-		k8sClient: k8sClient,
-		config:    c,
-		stopCh:    make(chan struct{}),
+		authn:         &Authenticator{authURL: c.KeystoneURL, client: keystoneClient, syncConfig: sc, providers: providers},
+		authz:         &Authorizer{pl: policy},
+		k8sClient:     k8sClient,
+		config:        c,
+		stopCh:        make(chan struct{}),
+		refreshTokens: newRefreshTokenStore(),
+	}
+
+	if c.SigningKeyFile != "" {
+		issuer, err := newJWTIssuer(c.SigningAlgorithm, c.SigningKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize token issuer: %v", err)
+		}
+		keystoneAuth.issuer = issuer
+	}
+
+	if c.CacheEnabled {
+		keystoneCache := newCachedKeystoneClient(func(token string) (k8suser.Info, error) {
+			info, ok, err := keystoneAuth.authn.authenticateTokenUncached(token)
+			if !ok {
+				if err == nil {
+					err = errTokenRejected{}
+				}
+				return nil, err
+			}
+			return info, nil
+		}, c)
+		keystoneAuth.authn.cache = keystoneCache
+	}
+
+	// The usercontroller needs a Kubernetes client even when no configmap
+	// or sync config file option created one above.
+	if c.EnableUserSync && k8sClient == nil {
+		k8sClient, err = createKubernetesClient(c.Kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get kubernetes client for user sync: %v", err)
+		}
+		keystoneAuth.k8sClient = k8sClient
 	}
 
 	if k8sClient != nil {
@@ -483,16 +396,65 @@ func NewKeystoneAuth(c *Config) (*KeystoneAuth, error) {
 		keystoneAuth.cmLister = cmInformer.Lister()
 		keystoneAuth.cmListerSynced = cmInformer.Informer().HasSynced
 		keystoneAuth.queue = queue
+
+		if keystoneAuth.issuer != nil && c.SigningKeySecretName != "" {
+			secretInformer := kubeInformerFactory.Core().V1().Secrets()
+			secretInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+				AddFunc:    keystoneAuth.reloadSigningKeyFromObj,
+				UpdateFunc: func(old, new interface{}) { keystoneAuth.reloadSigningKeyFromObj(new) },
+			})
+			keystoneAuth.secretLister = secretInformer.Lister()
+			keystoneAuth.secretListerSynced = secretInformer.Informer().HasSynced
+		}
+	}
+
+	if c.EnableUserSync {
+		if k8sClient == nil {
+			return nil, fmt.Errorf("EnableUserSync requires Kubeconfig to be set")
+		}
+
+		dynamicClient, err := createDynamicClient(c.Kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get dynamic client for user sync: %v", err)
+		}
+
+		apiextClient, err := createAPIExtensionsClient(c.Kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get apiextensions client for user sync: %v", err)
+		}
+
+		var roleMappings []usercontroller.RoleMapping
+		if sc != nil {
+			for _, rm := range sc.RoleMappings {
+				roleMappings = append(roleMappings, usercontroller.RoleMapping{KeystoneRole: rm.KeystoneRole, KubeGroups: rm.KubeGroups})
+			}
+		}
+
+		userSyncPeriod := c.UserSyncPeriod
+		if userSyncPeriod <= 0 {
+			userSyncPeriod = 5 * time.Minute
+		}
+
+		keystoneAuth.userController = usercontroller.NewController(keystoneClient, k8sClient, dynamicClient, apiextClient, roleMappings, userSyncPeriod)
 	}
 
 	return keystoneAuth, nil
 }
 
-func getField(data map[string]interface{}, name string) string {
-	if v, ok := data[name]; ok {
-		return v.(string)
+func createDynamicClient(kubeConfig string) (dynamic.Interface, error) {
+	cfg, err := clientcmd.BuildConfigFromFlags("", kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	return dynamic.NewForConfig(cfg)
+}
+
+func createAPIExtensionsClient(kubeConfig string) (apiextensionsclientset.Interface, error) {
+	cfg, err := clientcmd.BuildConfigFromFlags("", kubeConfig)
+	if err != nil {
+		return nil, err
 	}
-	return ""
+	return apiextensionsclientset.NewForConfig(cfg)
 }
 
 // Construct a Keystone v3 client, bail out if we cannot find the v3 API endpoint
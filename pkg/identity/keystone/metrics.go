@@ -0,0 +1,41 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	cacheHitsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "keystone_auth_cache_hits_total",
+		Help: "Number of token cache lookups, by result (success, error or miss).",
+	}, []string{"result"})
+
+	authLatencySeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "keystone_auth_latency_seconds",
+		Help:    "Latency of Keystone token validation round-trips that missed the cache.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"result"})
+
+	authErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "keystone_auth_errors_total",
+		Help: "Number of failed Keystone token validations, by reason.",
+	}, []string{"reason"})
+)
+
+func init() {
+	prometheus.MustRegister(cacheHitsTotal, authLatencySeconds, authErrorsTotal)
+}
@@ -0,0 +1,248 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	k8suser "k8s.io/apiserver/pkg/authentication/user"
+)
+
+const (
+	defaultAccessTokenTTL  = time.Hour
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// oauthErrorResponse follows the error shape of RFC 6749 section 5.2.
+type oauthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// oauthTokenResponse follows RFC 6749 section 5.1.
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// refreshTokenEntry is a single issued refresh token: the identity it
+// resolves to, and when it stops being valid.
+type refreshTokenEntry struct {
+	info   k8suser.Info
+	expiry time.Time
+}
+
+// refreshTokenStore hands out opaque refresh tokens for a password grant
+// and resolves them back to the user they were issued to, for as long as
+// they haven't expired. It is intentionally simple (in-memory,
+// process-local): losing it on restart just means the refresh token
+// holder has to log in again.
+type refreshTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]refreshTokenEntry
+}
+
+func newRefreshTokenStore() *refreshTokenStore {
+	return &refreshTokenStore{tokens: make(map[string]refreshTokenEntry)}
+}
+
+func (s *refreshTokenStore) issue(info k8suser.Info, ttl time.Duration) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	s.mu.Lock()
+	s.tokens[token] = refreshTokenEntry{info: info, expiry: time.Now().Add(ttl)}
+	s.mu.Unlock()
+
+	return token, nil
+}
+
+func (s *refreshTokenStore) lookup(token string) (k8suser.Info, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.tokens[token]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expiry) {
+		delete(s.tokens, token)
+		return nil, false
+	}
+	return entry.info, true
+}
+
+// oauthTokenHandler implements the password and refresh_token grants of
+// the OAuth2 token endpoint (RFC 6749 sections 4.3 and 6), minting a
+// short-lived JWT so subsequent requests don't each round-trip Keystone.
+func (k *KeystoneAuth) oauthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	var info k8suser.Info
+	switch grantType := r.PostFormValue("grant_type"); grantType {
+	case "password":
+		username := r.PostFormValue("username")
+		password := r.PostFormValue("password")
+		if username == "" || password == "" {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_request", "username and password are required")
+			return
+		}
+
+		authenticated, err := authenticateKeystonePassword(k.authn.authURL, username, password)
+		if err != nil {
+			glog.V(4).Infof("oauthTokenHandler: password grant failed for %s: %v", username, err)
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_grant", "invalid username or password")
+			return
+		}
+		info = authenticated
+
+	case "refresh_token":
+		refreshToken := r.PostFormValue("refresh_token")
+		if refreshToken == "" {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_request", "refresh_token is required")
+			return
+		}
+
+		cached, ok := k.refreshTokens.lookup(refreshToken)
+		if !ok {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "unknown or expired refresh_token")
+			return
+		}
+		info = cached
+
+	default:
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", grantType)
+		return
+	}
+
+	accessTTL := k.config.AccessTokenTTL
+	if accessTTL <= 0 {
+		accessTTL = defaultAccessTokenTTL
+	}
+
+	accessToken, err := k.issuer.IssueTo(info, accessTTL)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	refreshTTL := k.config.RefreshTokenTTL
+	if refreshTTL <= 0 {
+		refreshTTL = defaultRefreshTokenTTL
+	}
+
+	refreshToken, err := k.refreshTokens.issue(info, refreshTTL)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, oauthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(accessTTL.Seconds()),
+		RefreshToken: refreshToken,
+	})
+}
+
+// oauthAuthorizeHandler implements the resource owner password credentials
+// variant of the authorize endpoint: it accepts the same credentials as
+// the password grant and redirects to redirect_uri with an access token,
+// so a kubectl plugin can drive a browser-less login without ever talking
+// to Keystone directly. It is POST-only and only redirects to a
+// Config.OAuthRedirectURIs entry: accepting an arbitrary caller-supplied
+// redirect_uri would make this an open redirect handing out bearer tokens
+// to anywhere the caller likes, and accepting credentials via GET would
+// put them in proxy and access logs.
+func (k *KeystoneAuth) oauthAuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	redirectURI := r.PostFormValue("redirect_uri")
+	if redirectURI == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "redirect_uri is required")
+		return
+	}
+	if !isRegisteredRedirectURI(k.config.OAuthRedirectURIs, redirectURI) {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "redirect_uri is not registered")
+		return
+	}
+
+	username := r.PostFormValue("username")
+	password := r.PostFormValue("password")
+	info, err := authenticateKeystonePassword(k.authn.authURL, username, password)
+	if err != nil {
+		writeOAuthError(w, http.StatusUnauthorized, "access_denied", "invalid username or password")
+		return
+	}
+
+	accessTTL := k.config.AccessTokenTTL
+	if accessTTL <= 0 {
+		accessTTL = defaultAccessTokenTTL
+	}
+
+	accessToken, err := k.issuer.IssueTo(info, accessTTL)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	http.Redirect(w, r, redirectURI+"#access_token="+accessToken+"&token_type=Bearer", http.StatusFound)
+}
+
+// isRegisteredRedirectURI reports whether redirectURI exactly matches one
+// of the configured, registered client redirect URIs.
+func isRegisteredRedirectURI(registered []string, redirectURI string) bool {
+	for _, u := range registered {
+		if u == redirectURI {
+			return true
+		}
+	}
+	return false
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, oauthErrorResponse{Error: code, ErrorDescription: description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	output, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(output)
+}
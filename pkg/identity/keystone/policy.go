@@ -0,0 +1,61 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// policy describes a single authorization rule: the subjects it applies to
+// and the resource/non-resource attributes it matches.
+type policy struct {
+	Resource struct {
+		Verbs     []string `json:"verbs"`
+		Resources []string `json:"resources"`
+		Version   string   `json:"version"`
+		APIGroup  string   `json:"apiGroup"`
+		Namespace string   `json:"namespace"`
+	} `json:"resource,omitempty"`
+	NonResource struct {
+		Verbs []string `json:"verbs"`
+		Path  string   `json:"path"`
+	} `json:"nonresource,omitempty"`
+	Match []struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	} `json:"match"`
+}
+
+// policyList is the full set of authorization rules loaded either from a
+// file or from the policy configmap.
+type policyList []*policy
+
+// newFromFile reads and parses a policy definition file.
+func newFromFile(path string) (policyList, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pl policyList
+	if err := json.Unmarshal(data, &pl); err != nil {
+		return nil, err
+	}
+
+	return pl, nil
+}
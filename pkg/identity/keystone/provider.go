@@ -0,0 +1,77 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"fmt"
+
+	k8suser "k8s.io/apiserver/pkg/authentication/user"
+)
+
+// IdentityProvider authenticates a bearer token against a single identity
+// backend (Keystone, GitHub OAuth, a generic OIDC issuer, LDAP, ...).
+// KeystoneAuth tries a chain of these in order until one of them accepts
+// the token.
+type IdentityProvider interface {
+	// Authenticate validates token and returns the identity it maps to.
+	// Implementations should return an error when the token is not one
+	// they issued, so the chain can move on to the next provider.
+	Authenticate(token string) (k8suser.Info, error)
+	// Type is the short, stable name this provider registers itself as,
+	// e.g. "keystone", "github", "oidc", "ldap". It is also recorded in
+	// userInfo.Extra["identity_provider"] so downstream consumers know
+	// which backend vouched for a user.
+	Type() string
+}
+
+// IdentityProviderFactory builds an IdentityProvider from the webhook's
+// Config. Providers register a factory under a unique name via
+// RegisterIdentityProvider so operators can enable them by name in
+// Config.IdentityProviders.
+type IdentityProviderFactory func(c *Config) (IdentityProvider, error)
+
+var identityProviderFactories = map[string]IdentityProviderFactory{}
+
+// RegisterIdentityProvider makes an identity provider available for use by
+// name. It is expected to be called from an init() function of the package
+// implementing the provider.
+func RegisterIdentityProvider(name string, factory IdentityProviderFactory) {
+	identityProviderFactories[name] = factory
+}
+
+// buildIdentityProviderChain constructs the ordered list of providers
+// AuthenticateToken tries for every incoming token. Keystone is always
+// first, since it backs the cluster's own service accounts and is the
+// provider operators expect to work out of the box; additional providers
+// named in c.IdentityProviders are appended in the order given.
+func buildIdentityProviderChain(c *Config, keystone IdentityProvider) ([]IdentityProvider, error) {
+	providers := []IdentityProvider{keystone}
+
+	for _, name := range c.IdentityProviders {
+		factory, ok := identityProviderFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown identity provider %q", name)
+		}
+		provider, err := factory(c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize identity provider %q: %v", name, err)
+		}
+		providers = append(providers, provider)
+	}
+
+	return providers, nil
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// roleMapping maps a Keystone role name to the Kubernetes groups a user
+// holding that role should be placed in.
+type roleMapping struct {
+	KeystoneRole string   `yaml:"keystone_role"`
+	KubeGroups   []string `yaml:"kube_groups"`
+}
+
+// syncConfig describes how Keystone roles are mapped onto Kubernetes
+// groups when synchronizing user identity.
+type syncConfig struct {
+	RoleMappings []roleMapping `yaml:"role-mappings"`
+}
+
+// newSyncConfig returns an empty sync configuration.
+func newSyncConfig() syncConfig {
+	return syncConfig{RoleMappings: []roleMapping{}}
+}
+
+// newSyncConfigFromFile reads and parses a sync configuration file.
+func newSyncConfigFromFile(path string) (*syncConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sc := newSyncConfig()
+	if err := yaml.Unmarshal(data, &sc); err != nil {
+		return nil, err
+	}
+
+	return &sc, nil
+}
+
+// validate sanity-checks the sync configuration, erroring out on
+// role-mappings with no keystone role or no kubernetes groups.
+func (sc *syncConfig) validate() error {
+	for _, rm := range sc.RoleMappings {
+		if rm.KeystoneRole == "" {
+			return fmt.Errorf("role-mappings entry is missing keystone_role")
+		}
+		if len(rm.KubeGroups) == 0 {
+			return fmt.Errorf("role-mappings entry for %s has no kube_groups", rm.KeystoneRole)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/golang/glog"
+
+	apiv1 "k8s.io/api/core/v1"
+	k8suser "k8s.io/apiserver/pkg/authentication/user"
+)
+
+// signingKeyDataKey is the key looked up in the signing key secret's Data,
+// mirroring how TLS secrets store tls.crt/tls.key under fixed keys.
+const signingKeyDataKey = "signing-key"
+
+// reloadSigningKeyFromObj is the informer event handler for the signing
+// key secret: it hot-reloads the issuer's key material whenever the
+// secret named Config.SigningKeySecretName is created or updated, so keys
+// can be rotated without restarting the webhook.
+func (k *KeystoneAuth) reloadSigningKeyFromObj(obj interface{}) {
+	secret, ok := obj.(*apiv1.Secret)
+	if !ok || secret.Name != k.config.SigningKeySecretName || secret.Namespace != cmNamespace {
+		return
+	}
+
+	issuer, ok := k.issuer.(*jwtIssuer)
+	if !ok {
+		return
+	}
+
+	keyData, ok := secret.Data[signingKeyDataKey]
+	if !ok {
+		glog.Errorf("signing key secret %s is missing key %q", secret.Name, signingKeyDataKey)
+		return
+	}
+
+	if err := issuer.setKey(keyData); err != nil {
+		glog.Errorf("failed to reload signing key from secret %s: %v", secret.Name, err)
+		return
+	}
+
+	glog.Infof("Signing key reloaded from secret %s.", secret.Name)
+}
+
+// TokenIssuer mints and verifies the short-lived bearer tokens the webhook
+// hands out from /oauth/token, so repeated requests from the same user
+// don't each cost a round-trip to Keystone.
+type TokenIssuer interface {
+	// IssueTo mints a token encoding info, valid for ttl.
+	IssueTo(info k8suser.Info, ttl time.Duration) (string, error)
+	// Verify validates a token minted by IssueTo and returns the identity
+	// it encodes. It returns an error for tokens it did not issue, so it
+	// can sit in front of the Keystone provider in the auth chain.
+	Verify(token string) (k8suser.Info, error)
+}
+
+// jwtClaims is the payload embedded in tokens issued by jwtIssuer.
+type jwtClaims struct {
+	jwt.StandardClaims
+	UID    string              `json:"uid,omitempty"`
+	Groups []string            `json:"groups,omitempty"`
+	Extra  map[string][]string `json:"extra,omitempty"`
+}
+
+// jwtIssuer is the built-in TokenIssuer, backed by a signing key that can
+// be hot-reloaded by reloadSigningKeyFromObj.
+type jwtIssuer struct {
+	algorithm string
+
+	mu         sync.RWMutex
+	signingKey interface{} // []byte for HS256, *rsa.PrivateKey for RS256
+	verifyKey  interface{} // same as signingKey for HS256, *rsa.PublicKey for RS256
+}
+
+// newJWTIssuer builds a jwtIssuer from the configured algorithm and key
+// file, ready to be overridden later by reloadSigningKeyFromObj.
+func newJWTIssuer(algorithm, keyFile string) (*jwtIssuer, error) {
+	keyData, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key file %s: %v", keyFile, err)
+	}
+
+	issuer := &jwtIssuer{algorithm: algorithm}
+	if err := issuer.setKey(keyData); err != nil {
+		return nil, err
+	}
+	return issuer, nil
+}
+
+// setKey (re)parses keyData into the signing/verification material for the
+// issuer's algorithm. Called both at startup and by reloadSigningKeyFromObj.
+func (j *jwtIssuer) setKey(keyData []byte) error {
+	switch j.algorithm {
+	case "RS256":
+		signingKey, err := jwt.ParseRSAPrivateKeyFromPEM(keyData)
+		if err != nil {
+			return fmt.Errorf("failed to parse RS256 signing key: %v", err)
+		}
+		j.mu.Lock()
+		j.signingKey = signingKey
+		j.verifyKey = &signingKey.PublicKey
+		j.mu.Unlock()
+	case "HS256", "":
+		j.mu.Lock()
+		j.signingKey = keyData
+		j.verifyKey = keyData
+		j.mu.Unlock()
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", j.algorithm)
+	}
+	return nil
+}
+
+func (j *jwtIssuer) signingMethod() jwt.SigningMethod {
+	if j.algorithm == "RS256" {
+		return jwt.SigningMethodRS256
+	}
+	return jwt.SigningMethodHS256
+}
+
+// IssueTo implements TokenIssuer.
+func (j *jwtIssuer) IssueTo(info k8suser.Info, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := jwtClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   info.GetName(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+			Issuer:    "keystone-auth",
+		},
+		UID:    info.GetUID(),
+		Groups: info.GetGroups(),
+		Extra:  info.GetExtra(),
+	}
+
+	token := jwt.NewWithClaims(j.signingMethod(), claims)
+
+	j.mu.RLock()
+	key := j.signingKey
+	j.mu.RUnlock()
+
+	return token.SignedString(key)
+}
+
+// Verify implements TokenIssuer.
+func (j *jwtIssuer) Verify(tokenString string) (k8suser.Info, error) {
+	claims := &jwtClaims{}
+
+	j.mu.RLock()
+	verifyKey := j.verifyKey
+	j.mu.RUnlock()
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != j.signingMethod() {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return verifyKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	return &k8suser.DefaultInfo{
+		Name:   claims.Subject,
+		UID:    claims.UID,
+		Groups: claims.Groups,
+		Extra:  claims.Extra,
+	}, nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	k8suser "k8s.io/apiserver/pkg/authentication/user"
+)
+
+func writeTempKeyFile(t *testing.T, data []byte) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", "signing-key")
+	if err != nil {
+		t.Fatalf("failed to create temp key file: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("failed to write temp key file: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(f.Name()) })
+	return f.Name()
+}
+
+func TestJWTIssuerRoundTrip(t *testing.T) {
+	keyFile := writeTempKeyFile(t, []byte("super-secret-signing-key"))
+	issuer, err := newJWTIssuer("HS256", keyFile)
+	if err != nil {
+		t.Fatalf("newJWTIssuer: %v", err)
+	}
+
+	info := &k8suser.DefaultInfo{
+		Name:   "alice",
+		UID:    "uid-123",
+		Groups: []string{"admins", "devs"},
+		Extra:  map[string][]string{"project_id": {"p1"}},
+	}
+
+	token, err := issuer.IssueTo(info, time.Minute)
+	if err != nil {
+		t.Fatalf("IssueTo: %v", err)
+	}
+
+	got, err := issuer.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	if got.GetName() != info.Name || got.GetUID() != info.UID {
+		t.Fatalf("got %+v, want name=%s uid=%s", got, info.Name, info.UID)
+	}
+	if len(got.GetGroups()) != 2 {
+		t.Fatalf("got groups %v, want 2 entries", got.GetGroups())
+	}
+	if got.GetExtra()["project_id"][0] != "p1" {
+		t.Fatalf("got extra %v, want project_id=p1", got.GetExtra())
+	}
+}
+
+func TestJWTIssuerRejectsExpiredToken(t *testing.T) {
+	keyFile := writeTempKeyFile(t, []byte("super-secret-signing-key"))
+	issuer, err := newJWTIssuer("HS256", keyFile)
+	if err != nil {
+		t.Fatalf("newJWTIssuer: %v", err)
+	}
+
+	token, err := issuer.IssueTo(&k8suser.DefaultInfo{Name: "alice"}, -time.Minute)
+	if err != nil {
+		t.Fatalf("IssueTo: %v", err)
+	}
+
+	if _, err := issuer.Verify(token); err == nil {
+		t.Fatal("expected Verify to reject an expired token")
+	}
+}
+
+func TestJWTIssuerRejectsTokenFromAnotherKey(t *testing.T) {
+	keyFileA := writeTempKeyFile(t, []byte("key-a-------------------"))
+	keyFileB := writeTempKeyFile(t, []byte("key-b-------------------"))
+
+	issuerA, err := newJWTIssuer("HS256", keyFileA)
+	if err != nil {
+		t.Fatalf("newJWTIssuer A: %v", err)
+	}
+	issuerB, err := newJWTIssuer("HS256", keyFileB)
+	if err != nil {
+		t.Fatalf("newJWTIssuer B: %v", err)
+	}
+
+	token, err := issuerA.IssueTo(&k8suser.DefaultInfo{Name: "alice"}, time.Minute)
+	if err != nil {
+		t.Fatalf("IssueTo: %v", err)
+	}
+
+	if _, err := issuerB.Verify(token); err == nil {
+		t.Fatal("expected Verify to reject a token signed with a different key")
+	}
+}
+
+func TestJWTIssuerSetKeyReloadsSigningKey(t *testing.T) {
+	keyFile := writeTempKeyFile(t, []byte("key-a-------------------"))
+	issuer, err := newJWTIssuer("HS256", keyFile)
+	if err != nil {
+		t.Fatalf("newJWTIssuer: %v", err)
+	}
+
+	token, err := issuer.IssueTo(&k8suser.DefaultInfo{Name: "alice"}, time.Minute)
+	if err != nil {
+		t.Fatalf("IssueTo: %v", err)
+	}
+
+	if err := issuer.setKey([]byte("key-b-------------------")); err != nil {
+		t.Fatalf("setKey: %v", err)
+	}
+
+	if _, err := issuer.Verify(token); err == nil {
+		t.Fatal("expected Verify to reject a token signed with the now-rotated-away key")
+	}
+}
@@ -0,0 +1,271 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package usercontroller syncs Keystone users, projects and role
+// assignments into Kubernetes User/Group custom resources, so cluster
+// admins can see and RBAC against Keystone principals as first-class
+// Kubernetes objects instead of only at webhook time.
+package usercontroller
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/projects"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/roles"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/users"
+
+	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	runtimeutil "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const maxRetries = 5
+
+// RoleMapping maps a Keystone role name to the Kubernetes groups a user
+// holding it should belong to, mirroring the webhook's own sync config so
+// the CRs and the webhook never disagree about group membership.
+type RoleMapping struct {
+	KeystoneRole string
+	KubeGroups   []string
+}
+
+// Controller periodically enumerates Keystone users, projects and role
+// assignments, reconciling them into User/Group custom resources and the
+// ClusterRoleBindings the configured RoleMappings call for.
+type Controller struct {
+	keystoneClient *gophercloud.ServiceClient
+	k8sClient      kubernetes.Interface
+	crdClient      dynamic.Interface
+	apiextClient   apiextensionsclientset.Interface
+	roleMappings   []RoleMapping
+	resyncPeriod   time.Duration
+
+	queue workqueue.RateLimitingInterface
+}
+
+// NewController builds a Controller. It does not start enumerating
+// Keystone until Run is called. apiextClient is used once, at the start of
+// Run, to make sure the User/Group CustomResourceDefinitions crdClient
+// upserts instances of actually exist on the cluster.
+func NewController(keystoneClient *gophercloud.ServiceClient, k8sClient kubernetes.Interface, crdClient dynamic.Interface, apiextClient apiextensionsclientset.Interface, roleMappings []RoleMapping, resyncPeriod time.Duration) *Controller {
+	return &Controller{
+		keystoneClient: keystoneClient,
+		k8sClient:      k8sClient,
+		crdClient:      crdClient,
+		apiextClient:   apiextClient,
+		roleMappings:   roleMappings,
+		resyncPeriod:   resyncPeriod,
+		queue:          workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+}
+
+// Run ensures the User/Group CRDs exist, then starts the periodic Keystone
+// enumeration and the reconcile workers, blocking until stopCh is closed.
+func (c *Controller) Run(stopCh <-chan struct{}) {
+	defer c.queue.ShutDown()
+
+	if err := ensureCRDs(c.apiextClient); err != nil {
+		runtimeutil.HandleError(fmt.Errorf("usercontroller: failed to ensure User/Group CRDs exist: %v", err))
+		return
+	}
+
+	go wait.Until(c.runWorker, time.Second, stopCh)
+	go wait.Until(c.enumerateKeystone, c.resyncPeriod, stopCh)
+
+	// Run the first enumeration immediately instead of waiting a full
+	// resyncPeriod before the User/Group CRs appear.
+	c.enumerateKeystone()
+
+	<-stopCh
+}
+
+// enumerateKeystone lists every Keystone user and project, enqueues a
+// reconcile key for each, and garbage collects CRs/ClusterRoleBindings left
+// behind by principals that no longer exist. The actual Keystone reads for
+// a given principal happen in processItem so a transient failure only
+// delays that one principal; a failure here only delays this cycle's GC.
+func (c *Controller) enumerateKeystone() {
+	glog.V(4).Info("usercontroller: enumerating Keystone users and projects")
+
+	listFailed := false
+	userIDs, err := listUserIDs(c.keystoneClient)
+	if err != nil {
+		listFailed = true
+		runtimeutil.HandleError(fmt.Errorf("usercontroller: failed to list keystone users: %v", err))
+	}
+	currentUserIDs := make(map[string]bool, len(userIDs))
+	for _, id := range userIDs {
+		currentUserIDs[id] = true
+		c.queue.Add("user/" + id)
+	}
+
+	projectIDs, err := listProjectIDs(c.keystoneClient)
+	if err != nil {
+		listFailed = true
+		runtimeutil.HandleError(fmt.Errorf("usercontroller: failed to list keystone projects: %v", err))
+	}
+	currentProjectIDs := make(map[string]bool, len(projectIDs))
+	for _, id := range projectIDs {
+		currentProjectIDs[id] = true
+		c.queue.Add("project/" + id)
+	}
+
+	if listFailed {
+		return
+	}
+	c.garbageCollect(currentUserIDs, currentProjectIDs)
+}
+
+func (c *Controller) runWorker() {
+	for c.processNextItem() {
+	}
+}
+
+func (c *Controller) processNextItem() bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	err := c.processItem(key.(string))
+	if err == nil {
+		c.queue.Forget(key)
+	} else if c.queue.NumRequeues(key) < maxRetries {
+		glog.Errorf("usercontroller: failed to process key %s (will retry): %v", key, err)
+		c.queue.AddRateLimited(key)
+	} else {
+		glog.Errorf("usercontroller: failed to process key %s (giving up): %v", key, err)
+		c.queue.Forget(key)
+		runtimeutil.HandleError(err)
+	}
+
+	return true
+}
+
+func (c *Controller) processItem(key string) error {
+	kind, id, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+
+	switch kind {
+	case "user":
+		return c.reconcileUser(id)
+	case "project":
+		return c.reconcileProject(id)
+	default:
+		return fmt.Errorf("unknown reconcile key %q", key)
+	}
+}
+
+func splitKey(key string) (kind, id string, err error) {
+	for i := 0; i < len(key); i++ {
+		if key[i] == '/' {
+			return key[:i], key[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("malformed reconcile key %q", key)
+}
+
+func listUserIDs(client *gophercloud.ServiceClient) ([]string, error) {
+	var ids []string
+	pages, err := users.List(client, users.ListOpts{}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	all, err := users.ExtractUsers(pages)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range all {
+		ids = append(ids, u.ID)
+	}
+	return ids, nil
+}
+
+func listProjectIDs(client *gophercloud.ServiceClient) ([]string, error) {
+	var ids []string
+	pages, err := projects.List(client, projects.ListOpts{}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	all, err := projects.ExtractProjects(pages)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range all {
+		ids = append(ids, p.ID)
+	}
+	return ids, nil
+}
+
+// roleAssignment is the subset of a Keystone role assignment this
+// controller cares about: which role, scoped to which project. Assignments
+// scoped to a domain rather than a project (ProjectID == "") don't map to
+// a ProjectMembership/Group member and are left out by the callers below.
+type roleAssignment struct {
+	ProjectID string
+	RoleName  string
+}
+
+func listUserRoleAssignments(client *gophercloud.ServiceClient, userID string) ([]roleAssignment, error) {
+	pages, err := roles.ListAssignments(client, roles.ListAssignmentsOpts{UserID: userID}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	assignments, err := roles.ExtractRoleAssignments(pages)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]roleAssignment, 0, len(assignments))
+	for _, a := range assignments {
+		result = append(result, roleAssignment{ProjectID: a.Scope.Project.ID, RoleName: a.Role.Name})
+	}
+	return result, nil
+}
+
+// listProjectMemberIDs returns the distinct Keystone user ids holding a
+// role assignment scoped to projectID.
+func listProjectMemberIDs(client *gophercloud.ServiceClient, projectID string) ([]string, error) {
+	pages, err := roles.ListAssignments(client, roles.ListAssignmentsOpts{ScopeProjectID: projectID}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	assignments, err := roles.ExtractRoleAssignments(pages)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var members []string
+	for _, a := range assignments {
+		if a.User.ID == "" || seen[a.User.ID] {
+			continue
+		}
+		seen[a.User.ID] = true
+		members = append(members, a.User.ID)
+	}
+	sort.Strings(members)
+	return members, nil
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package usercontroller
+
+import (
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/cloud-provider-openstack/pkg/apis/keystone/v1alpha1"
+)
+
+// preserveUnknownFields lets the CRD accept the Spec/Status shape of
+// v1alpha1.User/Group as-is without also maintaining a hand-written
+// OpenAPI schema for it here.
+var preserveUnknownFields = true
+
+// ensureCRDs makes sure the User and Group CustomResourceDefinitions this
+// controller upserts instances of actually exist, so the dynamic client's
+// Create/Update calls in upsert don't fail with "no matches for kind" on a
+// cluster that has never run this controller before.
+func ensureCRDs(client apiextensionsclientset.Interface) error {
+	for _, crd := range []*apiextensionsv1.CustomResourceDefinition{usersCRD(), groupsCRD()} {
+		_, err := client.ApiextensionsV1().CustomResourceDefinitions().Create(crd)
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func usersCRD() *apiextensionsv1.CustomResourceDefinition {
+	return &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "users." + v1alpha1.GroupName},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: v1alpha1.GroupName,
+			Names: apiextensionsv1.CustomResourceDefinitionNames{
+				Plural:   "users",
+				Singular: "user",
+				Kind:     "User",
+				ListKind: "UserList",
+			},
+			Scope:    apiextensionsv1.ClusterScoped,
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{crdVersion()},
+		},
+	}
+}
+
+func groupsCRD() *apiextensionsv1.CustomResourceDefinition {
+	return &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "groups." + v1alpha1.GroupName},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: v1alpha1.GroupName,
+			Names: apiextensionsv1.CustomResourceDefinitionNames{
+				Plural:   "groups",
+				Singular: "group",
+				Kind:     "Group",
+				ListKind: "GroupList",
+			},
+			Scope:    apiextensionsv1.ClusterScoped,
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{crdVersion()},
+		},
+	}
+}
+
+func crdVersion() apiextensionsv1.CustomResourceDefinitionVersion {
+	return apiextensionsv1.CustomResourceDefinitionVersion{
+		Name:    "v1alpha1",
+		Served:  true,
+		Storage: true,
+		Schema: &apiextensionsv1.CustomResourceValidation{
+			OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{
+				Type:                   "object",
+				XPreserveUnknownFields: &preserveUnknownFields,
+			},
+		},
+	}
+}
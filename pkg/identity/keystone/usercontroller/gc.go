@@ -0,0 +1,105 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package usercontroller
+
+import (
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	runtimeutil "k8s.io/apimachinery/pkg/util/runtime"
+)
+
+// garbageCollect removes User/Group CRs whose backing Keystone user/project
+// no longer exists, and ClusterRoleBindings this controller owns whose
+// group is no longer called for by any configured RoleMapping. Without
+// this, a deleted Keystone principal would leave its CR (and, on a
+// RoleMapping removal, its binding) behind forever, since enumerateKeystone
+// only ever enqueues ids it currently sees.
+//
+// Membership-driven bindings (a user losing, rather than a RoleMapping
+// dropping, a role) are left alone here: a binding is shared by every user
+// mapped to that group, and reconcileUser has no way to tell "nobody holds
+// this role right now" apart from "the user I'm processing doesn't", so
+// deleting on that signal would flap the binding out from under other
+// members.
+func (c *Controller) garbageCollect(currentUserIDs, currentProjectIDs map[string]bool) {
+	if err := c.gcCRs(usersGVR, currentUserIDs); err != nil {
+		runtimeutil.HandleError(fmt.Errorf("usercontroller: failed to garbage collect User CRs: %v", err))
+	}
+	if err := c.gcCRs(groupsGVR, currentProjectIDs); err != nil {
+		runtimeutil.HandleError(fmt.Errorf("usercontroller: failed to garbage collect Group CRs: %v", err))
+	}
+	if err := c.gcClusterRoleBindings(); err != nil {
+		runtimeutil.HandleError(fmt.Errorf("usercontroller: failed to garbage collect ClusterRoleBindings: %v", err))
+	}
+}
+
+// gcCRs deletes every CR of the given resource whose spec.keystoneID isn't
+// in currentIDs.
+func (c *Controller) gcCRs(gvr schema.GroupVersionResource, currentIDs map[string]bool) error {
+	client := c.crdClient.Resource(gvr)
+	list, err := client.List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, item := range list.Items {
+		keystoneID, _, err := unstructured.NestedString(item.Object, "spec", "keystoneID")
+		if err != nil || keystoneID == "" || currentIDs[keystoneID] {
+			continue
+		}
+		if err := client.Delete(item.GetName(), &metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete %s: %v", item.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// gcClusterRoleBindings deletes every keystone-sync: binding this
+// controller owns whose group is no longer produced by any configured
+// RoleMapping.
+func (c *Controller) gcClusterRoleBindings() error {
+	wantGroups := map[string]bool{}
+	for _, mapping := range c.roleMappings {
+		for _, g := range mapping.KubeGroups {
+			wantGroups[g] = true
+		}
+	}
+
+	bindings, err := c.k8sClient.RbacV1().ClusterRoleBindings().List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, crb := range bindings.Items {
+		if !strings.HasPrefix(crb.Name, rbacSyncPrefix) {
+			continue
+		}
+		group := strings.TrimPrefix(crb.Name, rbacSyncPrefix)
+		if wantGroups[group] {
+			continue
+		}
+		if err := c.k8sClient.RbacV1().ClusterRoleBindings().Delete(crb.Name, &metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete ClusterRoleBinding %s: %v", crb.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package usercontroller
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/projects"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/users"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"k8s.io/cloud-provider-openstack/pkg/apis/keystone/v1alpha1"
+)
+
+var (
+	usersGVR  = schema.GroupVersionResource{Group: v1alpha1.GroupName, Version: "v1alpha1", Resource: "users"}
+	groupsGVR = schema.GroupVersionResource{Group: v1alpha1.GroupName, Version: "v1alpha1", Resource: "groups"}
+)
+
+// rbacSyncPrefix namespaces the ClusterRoleBindings this controller owns,
+// so it never touches bindings an admin created by hand.
+const rbacSyncPrefix = "keystone-sync:"
+
+// reconcileUser fetches a single Keystone user and its role assignments,
+// upserts the matching User CR, and ensures the ClusterRoleBindings that
+// the configured RoleMappings call for.
+func (c *Controller) reconcileUser(id string) error {
+	user, err := users.Get(c.keystoneClient, id).Extract()
+	if err != nil {
+		if isKeystoneNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get keystone user %s: %v", id, err)
+	}
+
+	assignments, err := listUserRoleAssignments(c.keystoneClient, id)
+	if err != nil {
+		return fmt.Errorf("failed to list role assignments for user %s: %v", id, err)
+	}
+
+	kubeGroups := map[string]bool{}
+	projectRoles := map[string]map[string]bool{}
+	for _, assignment := range assignments {
+		for _, mapping := range c.roleMappings {
+			if mapping.KeystoneRole == assignment.RoleName {
+				for _, g := range mapping.KubeGroups {
+					kubeGroups[g] = true
+				}
+			}
+		}
+
+		if assignment.ProjectID == "" {
+			// Domain-scoped assignment: doesn't map to a project membership.
+			continue
+		}
+		if projectRoles[assignment.ProjectID] == nil {
+			projectRoles[assignment.ProjectID] = map[string]bool{}
+		}
+		projectRoles[assignment.ProjectID][assignment.RoleName] = true
+	}
+
+	projectMemberships := make([]v1alpha1.ProjectMembership, 0, len(projectRoles))
+	for projectID, roleSet := range projectRoles {
+		projectName := projectID
+		if project, err := projects.Get(c.keystoneClient, projectID).Extract(); err == nil {
+			projectName = project.Name
+		} else if !isKeystoneNotFound(err) {
+			return fmt.Errorf("failed to get keystone project %s for user %s: %v", projectID, id, err)
+		}
+
+		roleNames := make([]string, 0, len(roleSet))
+		for name := range roleSet {
+			roleNames = append(roleNames, name)
+		}
+		sort.Strings(roleNames)
+
+		projectMemberships = append(projectMemberships, v1alpha1.ProjectMembership{
+			ProjectID:   projectID,
+			ProjectName: projectName,
+			Roles:       roleNames,
+		})
+	}
+	sort.Slice(projectMemberships, func(i, j int) bool {
+		return projectMemberships[i].ProjectID < projectMemberships[j].ProjectID
+	})
+
+	cr := &v1alpha1.User{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "User"},
+		ObjectMeta: metav1.ObjectMeta{Name: sanitizeName(user.Name)},
+		Spec: v1alpha1.UserSpec{
+			KeystoneID:         user.ID,
+			Domain:             user.DomainID,
+			ProjectMemberships: projectMemberships,
+		},
+		Status: syncedStatus(),
+	}
+
+	if err := c.upsert(usersGVR, cr); err != nil {
+		return fmt.Errorf("failed to upsert User %s: %v", cr.Name, err)
+	}
+
+	for group := range kubeGroups {
+		if err := c.ensureClusterRoleBinding(group); err != nil {
+			return fmt.Errorf("failed to sync ClusterRoleBinding for group %s: %v", group, err)
+		}
+	}
+
+	return nil
+}
+
+// reconcileProject fetches a single Keystone project and upserts the
+// matching Group CR.
+func (c *Controller) reconcileProject(id string) error {
+	project, err := projects.Get(c.keystoneClient, id).Extract()
+	if err != nil {
+		if isKeystoneNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get keystone project %s: %v", id, err)
+	}
+
+	members, err := listProjectMemberIDs(c.keystoneClient, id)
+	if err != nil {
+		return fmt.Errorf("failed to list members for project %s: %v", id, err)
+	}
+
+	cr := &v1alpha1.Group{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "Group"},
+		ObjectMeta: metav1.ObjectMeta{Name: sanitizeName(project.Name)},
+		Spec: v1alpha1.GroupSpec{
+			KeystoneID: project.ID,
+			Domain:     project.DomainID,
+			Members:    members,
+		},
+		Status: syncedStatus(),
+	}
+
+	if err := c.upsert(groupsGVR, cr); err != nil {
+		return fmt.Errorf("failed to upsert Group %s: %v", cr.Name, err)
+	}
+
+	return nil
+}
+
+// upsert creates obj through the dynamic client, updating it in place if
+// it already exists.
+func (c *Controller) upsert(gvr schema.GroupVersionResource, obj runtime.Object) error {
+	unstructuredObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	u := &unstructuredObjWrapper{data: unstructuredObj}
+
+	client := c.crdClient.Resource(gvr)
+	_, err = client.Create(u.unstructured(), metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		existing, getErr := client.Get(u.name(), metav1.GetOptions{})
+		if getErr != nil {
+			return getErr
+		}
+		u.data["metadata"].(map[string]interface{})["resourceVersion"] = existing.GetResourceVersion()
+		_, err = client.Update(u.unstructured(), metav1.UpdateOptions{})
+	}
+	return err
+}
+
+// ensureClusterRoleBinding makes sure a ClusterRoleBinding exists that
+// binds the Kubernetes Group subject `group` to a ClusterRole of the same
+// name. Cluster admins are expected to define that ClusterRole; this
+// controller only owns the binding, named with rbacSyncPrefix so it never
+// collides with or clobbers a binding created by hand.
+func (c *Controller) ensureClusterRoleBinding(group string) error {
+	name := rbacSyncPrefix + group
+	crb := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     group,
+		},
+		Subjects: []rbacv1.Subject{
+			{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: group},
+		},
+	}
+
+	_, err := c.k8sClient.RbacV1().ClusterRoleBindings().Create(crb)
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+// syncedStatus is the SyncStatus stamped onto a CR after a successful
+// reconciliation against Keystone.
+func syncedStatus() v1alpha1.SyncStatus {
+	now := metav1.Now()
+	return v1alpha1.SyncStatus{
+		LastSyncTime: now,
+		Conditions: []metav1.Condition{
+			{
+				Type:               "Synced",
+				Status:             metav1.ConditionTrue,
+				Reason:             "KeystoneSync",
+				Message:            "Reconciled from Keystone",
+				LastTransitionTime: now,
+			},
+		},
+	}
+}
+
+// isKeystoneNotFound reports whether err is the gophercloud 404 returned by
+// a Get call against Keystone. Unlike k8s API errors, gophercloud errors
+// don't implement apierrors.APIStatus, so apierrors.IsNotFound never
+// matches them and has to be avoided here.
+func isKeystoneNotFound(err error) bool {
+	_, ok := err.(gophercloud.ErrDefault404)
+	return ok
+}
+
+func sanitizeName(name string) string {
+	out := make([]rune, 0, len(name))
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			out = append(out, r)
+		case r >= 'A' && r <= 'Z':
+			out = append(out, r+('a'-'A'))
+		default:
+			out = append(out, '-')
+		}
+	}
+	return string(out)
+}
+
+// unstructuredObjWrapper is a tiny helper around the map produced by
+// runtime.DefaultUnstructuredConverter so upsert can read/patch the
+// metadata it needs without pulling in the full unstructured.Unstructured
+// API in two places.
+type unstructuredObjWrapper struct {
+	data map[string]interface{}
+}
+
+func (u *unstructuredObjWrapper) name() string {
+	metadata, _ := u.data["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	return name
+}
+
+func (u *unstructuredObjWrapper) unstructured() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: u.data}
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	"github.com/golang/glog"
+	"sigs.k8s.io/yaml"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	k8suser "k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+// supportedAPIVersions maps the apiVersion strings the webhook accepts to
+// the group they belong to, so Handler can echo back whichever one the
+// caller used. authentication.k8s.io/v1 and authorization.k8s.io/v1 were
+// added alongside the long-supported v1beta1 so the webhook keeps working
+// against kube-apiservers that stopped sending v1beta1.
+var supportedAPIVersions = map[string]string{
+	"authentication.k8s.io/v1beta1": "authentication",
+	"authentication.k8s.io/v1":      "authentication",
+	"authorization.k8s.io/v1beta1":  "authorization",
+	"authorization.k8s.io/v1":       "authorization",
+}
+
+// typeMeta is just enough of an envelope to learn apiVersion/kind before
+// picking which typed struct to decode the rest of the body into.
+type typeMeta struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+}
+
+// errorResponse is the body writeStructuredError sends.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// writeStructuredError writes a JSON (or YAML, matching the request) body
+// describing the failure, instead of the bare text/plain errors the
+// webhook used to return.
+func writeStructuredError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	glog.Errorf("webhook request failed: %s", message)
+	body, contentType := encodeResponse(r, errorResponse{Error: message})
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// decodeRequestBody reads r.Body, translating YAML to JSON first if the
+// caller asked for it, so the rest of Handler only ever deals with JSON.
+func decodeRequestBody(r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if isYAML(r.Header.Get("Content-Type")) {
+		return yaml.YAMLToJSON(body)
+	}
+	return body, nil
+}
+
+// encodeResponse marshals body as JSON or YAML depending on what the
+// caller's Content-Type/Accept header asked for, defaulting to JSON.
+func encodeResponse(r *http.Request, body interface{}) ([]byte, string) {
+	output, err := json.MarshalIndent(body, "", "  ")
+	if err != nil {
+		return []byte(err.Error()), "text/plain"
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		accept = r.Header.Get("Content-Type")
+	}
+	if isYAML(accept) {
+		if yamlOutput, err := yaml.JSONToYAML(output); err == nil {
+			return yamlOutput, "application/yaml"
+		}
+	}
+	return output, "application/json"
+}
+
+func isYAML(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/yaml" || mediaType == "text/yaml"
+}
+
+// Handler serves the http requests
+func (k *KeystoneAuth) Handler(w http.ResponseWriter, r *http.Request) {
+	body, err := decodeRequestBody(r)
+	if err != nil {
+		writeStructuredError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var meta typeMeta
+	if err := json.Unmarshal(body, &meta); err != nil {
+		writeStructuredError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	group, ok := supportedAPIVersions[meta.APIVersion]
+	if !ok {
+		writeStructuredError(w, r, http.StatusBadRequest, fmt.Sprintf("unknown apiVersion %q", meta.APIVersion))
+		return
+	}
+
+	switch {
+	case group == "authentication" && meta.Kind == "TokenReview":
+		k.authenticateToken(w, r, body, meta.APIVersion)
+	case group == "authorization" && meta.Kind == "SubjectAccessReview":
+		k.authorizeToken(w, r, body, meta.APIVersion)
+	default:
+		writeStructuredError(w, r, http.StatusBadRequest, fmt.Sprintf("unknown kind/apiVersion %q %q", meta.Kind, meta.APIVersion))
+	}
+}
+
+func (k *KeystoneAuth) authenticateToken(w http.ResponseWriter, r *http.Request, body []byte, apiVersion string) {
+	var review authenticationv1.TokenReview
+	if err := json.Unmarshal(body, &review); err != nil {
+		writeStructuredError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	review.APIVersion = apiVersion
+
+	var user k8suser.Info
+	var authenticated bool
+	var err error
+
+	if k.issuer != nil {
+		if user, err = k.issuer.Verify(review.Spec.Token); err == nil {
+			authenticated = true
+		}
+	}
+
+	if !authenticated {
+		user, authenticated, err = k.authn.AuthenticateToken(review.Spec.Token)
+	}
+	glog.V(4).Infof("authenticateToken : %v, %v, %v\n", review.Spec.Token, user, err)
+
+	review.Status = authenticationv1.TokenReviewStatus{Authenticated: authenticated}
+	if authenticated {
+		review.Status.User = authenticationv1.UserInfo{
+			Username: user.GetName(),
+			UID:      user.GetUID(),
+			Groups:   user.GetGroups(),
+			Extra:    convertExtra(user.GetExtra()),
+		}
+	}
+
+	status := http.StatusOK
+	if !authenticated {
+		status = http.StatusUnauthorized
+	}
+
+	output, contentType := encodeResponse(r, review)
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write(output)
+}
+
+func (k *KeystoneAuth) authorizeToken(w http.ResponseWriter, r *http.Request, body []byte, apiVersion string) {
+	var review authorizationv1.SubjectAccessReview
+	if err := json.Unmarshal(body, &review); err != nil {
+		writeStructuredError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	review.APIVersion = apiVersion
+
+	glog.V(4).Infof("authorizeToken spec : %+v\n", review.Spec)
+
+	usr := &k8suser.DefaultInfo{
+		Name:   review.Spec.User,
+		Groups: review.Spec.Groups,
+		Extra:  convertExtraFromAuthzV1(review.Spec.Extra),
+	}
+	attrs := authorizer.AttributesRecord{User: usr}
+
+	switch {
+	case review.Spec.ResourceAttributes != nil:
+		ra := review.Spec.ResourceAttributes
+		attrs.ResourceRequest = true
+		attrs.Verb = ra.Verb
+		attrs.Namespace = ra.Namespace
+		attrs.APIGroup = ra.Group
+		attrs.APIVersion = ra.Version
+		attrs.Resource = ra.Resource
+		attrs.Name = ra.Name
+	case review.Spec.NonResourceAttributes != nil:
+		nra := review.Spec.NonResourceAttributes
+		attrs.ResourceRequest = false
+		attrs.Verb = nra.Verb
+		attrs.Path = nra.Path
+	default:
+		writeStructuredError(w, r, http.StatusBadRequest, "unable to find resourceAttributes or nonResourceAttributes")
+		return
+	}
+
+	var allowed authorizer.Decision
+	if len(k.authz.pl) > 0 {
+		var reason string
+		var err error
+		allowed, reason, err = k.authz.Authorize(attrs)
+		glog.V(4).Infof("authorizeToken: %v, %v, %v\n", allowed, reason, err)
+		if err != nil {
+			writeStructuredError(w, r, http.StatusInternalServerError, reason)
+			return
+		}
+	} else {
+		// The operator didn't set authorization policy, deny by default.
+		allowed = authorizer.DecisionDeny
+	}
+
+	review.Spec = authorizationv1.SubjectAccessReviewSpec{}
+	review.Status = authorizationv1.SubjectAccessReviewStatus{Allowed: allowed == authorizer.DecisionAllow}
+
+	output, contentType := encodeResponse(r, review)
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(output)
+}
+
+func convertExtra(extra map[string][]string) map[string]authenticationv1.ExtraValue {
+	if extra == nil {
+		return nil
+	}
+	out := make(map[string]authenticationv1.ExtraValue, len(extra))
+	for k, v := range extra {
+		out[k] = authenticationv1.ExtraValue(v)
+	}
+	return out
+}
+
+func convertExtraFromAuthzV1(extra map[string]authorizationv1.ExtraValue) map[string][]string {
+	if extra == nil {
+		return nil
+	}
+	out := make(map[string][]string, len(extra))
+	for k, v := range extra {
+		out[k] = []string(v)
+	}
+	return out
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	k8suser "k8s.io/apiserver/pkg/authentication/user"
+)
+
+// fakeProvider is a trivial IdentityProvider accepting a single fixed
+// token, used to exercise the webhook's TokenReview decoding without
+// talking to Keystone.
+type fakeProvider struct{}
+
+func (fakeProvider) Type() string { return "fake" }
+
+func (fakeProvider) Authenticate(token string) (k8suser.Info, error) {
+	if token != "good-token" {
+		return nil, errTokenRejected{}
+	}
+	return &k8suser.DefaultInfo{Name: "alice", UID: "uid-1", Groups: []string{"admins"}}, nil
+}
+
+func newTestKeystoneAuth() *KeystoneAuth {
+	return &KeystoneAuth{
+		authn: &Authenticator{providers: []IdentityProvider{fakeProvider{}}},
+		authz: &Authorizer{},
+	}
+}
+
+func postWebhook(t *testing.T, k *KeystoneAuth, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	k.Handler(rr, req)
+	return rr
+}
+
+func TestHandlerAuthenticatesTokenReviewV1(t *testing.T) {
+	k := newTestKeystoneAuth()
+	rr := postWebhook(t, k, `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"good-token"}}`)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, body %s", rr.Code, rr.Body.String())
+	}
+
+	var review authenticationv1.TokenReview
+	if err := json.Unmarshal(rr.Body.Bytes(), &review); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !review.Status.Authenticated {
+		t.Fatalf("expected authenticated=true, got %+v", review.Status)
+	}
+	if review.Status.User.Username != "alice" {
+		t.Fatalf("got username %q, want alice", review.Status.User.Username)
+	}
+	if review.APIVersion != "authentication.k8s.io/v1" {
+		t.Fatalf("got apiVersion %q, want it echoed back unchanged", review.APIVersion)
+	}
+}
+
+func TestHandlerAuthenticatesTokenReviewV1beta1(t *testing.T) {
+	k := newTestKeystoneAuth()
+	rr := postWebhook(t, k, `{"apiVersion":"authentication.k8s.io/v1beta1","kind":"TokenReview","spec":{"token":"good-token"}}`)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, body %s", rr.Code, rr.Body.String())
+	}
+
+	var review authenticationv1.TokenReview
+	if err := json.Unmarshal(rr.Body.Bytes(), &review); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !review.Status.Authenticated {
+		t.Fatalf("expected authenticated=true, got %+v", review.Status)
+	}
+	if review.APIVersion != "authentication.k8s.io/v1beta1" {
+		t.Fatalf("got apiVersion %q, want it echoed back unchanged", review.APIVersion)
+	}
+}
+
+func TestHandlerRejectsUnknownToken(t *testing.T) {
+	k := newTestKeystoneAuth()
+	rr := postWebhook(t, k, `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"bad-token"}}`)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("got status %d, want 401", rr.Code)
+	}
+}
+
+func TestHandlerAuthorizesSubjectAccessReviewV1(t *testing.T) {
+	k := newTestKeystoneAuth()
+	rr := postWebhook(t, k, `{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","spec":{"user":"alice","resourceAttributes":{"verb":"get","resource":"pods"}}}`)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, body %s", rr.Code, rr.Body.String())
+	}
+
+	var review authorizationv1.SubjectAccessReview
+	if err := json.Unmarshal(rr.Body.Bytes(), &review); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	// No policy is configured on k.authz, so the webhook denies by default.
+	if review.Status.Allowed {
+		t.Fatalf("expected denial with no configured policy, got %+v", review.Status)
+	}
+	if review.APIVersion != "authorization.k8s.io/v1" {
+		t.Fatalf("got apiVersion %q, want it echoed back unchanged", review.APIVersion)
+	}
+}
+
+func TestHandlerRejectsUnknownAPIVersion(t *testing.T) {
+	k := newTestKeystoneAuth()
+	rr := postWebhook(t, k, `{"apiVersion":"authentication.k8s.io/v2","kind":"TokenReview","spec":{"token":"good-token"}}`)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want 400", rr.Code)
+	}
+}